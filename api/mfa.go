@@ -0,0 +1,522 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/olartbaraq/spectrumshelf/db/sqlc"
+	"github.com/olartbaraq/spectrumshelf/utils"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// This file relies on utils.EncryptSecret/DecryptSecret for at-rest
+// protection of the TOTP secret and utils.PreAuthRole/UserRole alongside
+// the existing utils.AdminRole for the token's role claim. None of those
+// are added by this change - utils lives outside this module - so if
+// they don't already exist under these exact names, add them there
+// before this package will build.
+const (
+	totpDigits     = 6
+	totpStepPeriod = 30 * time.Second
+	totpSkewSteps  = 1
+	totpIssuer     = "RA_Nkan_API"
+	recoveryCodes  = 10
+)
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	QRCodePNG  []byte `json:"qr_code_png"`
+}
+
+type MFAActivateInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAVerifyInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFARecoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
+
+// generateTOTPSecret returns a fresh base32-encoded (no padding) shared
+// secret suitable for an authenticator app.
+func generateTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+// computeTOTP implements RFC 6238 with HMAC-SHA1, a 30s step and 6 digits.
+func computeTOTP(secret string, at time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := uint64(at.Unix() / int64(totpStepPeriod.Seconds()))
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := truncated % pow10(totpDigits)
+	return fmt.Sprintf("%0*d", totpDigits, code), nil
+}
+
+func pow10(n int) uint32 {
+	result := uint32(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
+
+// verifyTOTP checks code against the current step and the steps
+// immediately before/after it, to absorb clock drift between the server
+// and the user's authenticator app.
+func verifyTOTP(secret, code string) bool {
+	now := time.Now()
+
+	for skew := -totpSkewSteps; skew <= totpSkewSteps; skew++ {
+		at := now.Add(time.Duration(skew) * totpStepPeriod)
+		expected, err := computeTOTP(secret, at)
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	}
+	return codes, nil
+}
+
+func hashRecoveryCodes(codes []string) ([]string, error) {
+	hashed := make([]string, len(codes))
+	for i, code := range codes {
+		h, err := utils.GenerateHashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+		hashed[i] = h
+	}
+	return hashed, nil
+}
+
+func (u *User) mfaEnroll(ctx *gin.Context) {
+	userId, err := currentUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: Missing or invalid token",
+		})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	user, err := u.server.queries.GetUserById(context.Background(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	otpauthURL := buildOTPAuthURL(user.Email, secret)
+
+	png, err := qrcode.Encode(otpauthURL, qrcode.Medium, 256)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	encryptedSecret, err := utils.EncryptSecret(secret)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if _, err := u.server.queries.SetUserMFASecret(context.Background(), db.SetUserMFASecretParams{
+		ID:        userId,
+		MfaSecret: sql.NullString{String: encryptedSecret, Valid: true},
+	}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "scan the QR code with your authenticator app, then activate with a code",
+		"data": MFAEnrollResponse{
+			Secret:     secret,
+			OTPAuthURL: otpauthURL,
+			QRCodePNG:  png,
+		},
+	})
+}
+
+func (u *User) mfaActivate(ctx *gin.Context) {
+	userId, err := currentUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: Missing or invalid token",
+		})
+		return
+	}
+
+	input := MFAActivateInput{}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	state, err := u.server.queries.GetUserMFAState(context.Background(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if !state.MfaSecret.Valid {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "call /users/mfa/enroll first",
+		})
+		return
+	}
+
+	secret, err := utils.DecryptSecret(state.MfaSecret.String)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if !verifyTOTP(secret, input.Code) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid verification code",
+		})
+		return
+	}
+
+	plainCodes, err := generateRecoveryCodes(recoveryCodes)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	hashedCodes, err := hashRecoveryCodes(plainCodes)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if _, err := u.server.queries.ActivateUserMFA(context.Background(), db.ActivateUserMFAParams{
+		ID:               userId,
+		MfaRecoveryCodes: hashedCodes,
+	}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "MFA activated, store these recovery codes somewhere safe - they will not be shown again",
+		"data": MFARecoveryCodesResponse{
+			Codes: plainCodes,
+		},
+	})
+}
+
+// mfaVerify accepts either the short-lived pre-auth token issued at
+// login when the account has MFA enabled, or an already-full token from
+// a caller whose requireFreshMFA window (freshMFATimeout) has lapsed and
+// just needs to step back up without a full re-login. Either way, on
+// success it returns a full-scope token and marks the account freshly
+// verified.
+func (u *User) mfaVerify(ctx *gin.Context) {
+	tokenString, err := extractTokenFromRequest(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: Missing or invalid token",
+		})
+		return
+	}
+
+	userId, _, err := returnIdRole(tokenString)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: invalid token",
+		})
+		return
+	}
+
+	input := MFAVerifyInput{}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	state, err := u.server.queries.GetUserMFAState(context.Background(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if !state.MfaEnabled || !state.MfaSecret.Valid {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "MFA is not enabled for this account",
+		})
+		return
+	}
+
+	ok, consumedRecoveryCode := verifyTOTPOrRecoveryCode(state, input.Code)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid verification code",
+		})
+		return
+	}
+
+	if consumedRecoveryCode {
+		if _, err := u.server.queries.ConsumeUserRecoveryCode(context.Background(), db.ConsumeUserRecoveryCodeParams{
+			ID:      userId,
+			Column2: matchingRecoveryCodeHash(state, input.Code),
+		}); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"Error": err.Error(),
+			})
+			return
+		}
+	}
+
+	user, err := u.server.queries.GetUserById(context.Background(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	fullRole := utilsRoleFor(user.IsAdmin)
+
+	accessToken, err := tokenManager.GenerateToken(userId, fullRole)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if err := markMFAVerified(ctx, userId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "MFA verified",
+		"data": gin.H{
+			"token": accessToken,
+		},
+	})
+}
+
+func (u *User) mfaRecoveryCodes(ctx *gin.Context) {
+	userId, err := currentUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: Missing or invalid token",
+		})
+		return
+	}
+
+	state, err := u.server.queries.GetUserMFAState(context.Background(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if err := requireFreshMFA(ctx, userId, state.MfaEnabled); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	plainCodes, err := generateRecoveryCodes(recoveryCodes)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	hashedCodes, err := hashRecoveryCodes(plainCodes)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if _, err := u.server.queries.ActivateUserMFA(context.Background(), db.ActivateUserMFAParams{
+		ID:               userId,
+		MfaRecoveryCodes: hashedCodes,
+	}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "recovery codes regenerated, the previous set is now invalid",
+		"data": MFARecoveryCodesResponse{
+			Codes: plainCodes,
+		},
+	})
+}
+
+func verifyTOTPOrRecoveryCode(state db.GetUserMFAStateRow, code string) (ok bool, usedRecoveryCode bool) {
+	if state.MfaSecret.Valid {
+		secret, err := utils.DecryptSecret(state.MfaSecret.String)
+		if err == nil && verifyTOTP(secret, code) {
+			return true, false
+		}
+	}
+
+	if matchingRecoveryCodeHash(state, code) != "" {
+		return true, true
+	}
+
+	return false, false
+}
+
+func matchingRecoveryCodeHash(state db.GetUserMFAStateRow, code string) string {
+	for _, hashed := range state.MfaRecoveryCodes {
+		if utils.CheckPassword(code, hashed) == nil {
+			return hashed
+		}
+	}
+	return ""
+}
+
+func buildOTPAuthURL(email, secret string) string {
+	label := url.PathEscape(fmt.Sprintf("%s:%s", totpIssuer, email))
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", int(totpStepPeriod.Seconds())))
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// freshMFATimeout is how long a completed /users/mfa/verify is trusted
+// before sensitive actions demand another one.
+const freshMFATimeout = 5 * time.Minute
+
+func markMFAVerified(ctx context.Context, userId int64) error {
+	return Rdb.Set(ctx, mfaVerifiedKey(userId), "1", freshMFATimeout).Err()
+}
+
+func mfaVerifiedKey(userId int64) string {
+	return fmt.Sprintf("mfa_verified:%d", userId)
+}
+
+// mfaEnabledFor reports whether the given user has MFA enabled, treating
+// lookup failures as "not enabled" so a query error never itself blocks
+// an otherwise-authorized request.
+func mfaEnabledFor(u *User, userId int64) bool {
+	state, err := u.server.queries.GetUserMFAState(context.Background(), userId)
+	if err != nil {
+		return false
+	}
+	return state.MfaEnabled
+}
+
+// requireFreshMFA gates a handful of sensitive handlers (password
+// changes, account deactivation, the admin user list) behind a recently
+// completed MFA check, recorded in Redis by mfaVerify the same way
+// verification codes already are. Accounts without MFA enabled are
+// unaffected.
+func requireFreshMFA(ctx *gin.Context, userId int64, mfaEnabled bool) error {
+	if !mfaEnabled {
+		return nil
+	}
+
+	if err := Rdb.Get(ctx, mfaVerifiedKey(userId)).Err(); err != nil {
+		return errors.New("MFA verification required, call /users/mfa/verify first")
+	}
+
+	return nil
+}
@@ -0,0 +1,461 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	config "github.com/olartbaraq/spectrumshelf/configs"
+	db "github.com/olartbaraq/spectrumshelf/db/sqlc"
+	"github.com/olartbaraq/spectrumshelf/utils"
+)
+
+// oauthStateTimeout is how long a login attempt has to complete the
+// provider redirect before its anti-CSRF state token is considered stale.
+const oauthStateTimeout = 10 * time.Minute
+
+// UserInfoFields wraps the raw JSON object returned by a provider's
+// userinfo endpoint so individual handlers don't need to know whether a
+// given provider calls the avatar "picture", "avatar_url" or something
+// else entirely.
+type UserInfoFields map[string]any
+
+// GetString returns the string value stored under key, or "" if the key
+// is absent. A JSON number is formatted without a decimal point - GitHub's
+// userinfo returns "id" as a number rather than a string, and
+// json.Unmarshal into a map[string]any decodes it as float64.
+func (f UserInfoFields) GetString(key string) string {
+	switch v := f[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// GetStringFromKeysOrEmpty tries each key in order and returns the first
+// one that resolves to a non-empty string. This is what lets a single
+// mapping cover providers that disagree on field names, e.g. GitHub's
+// "name" vs an OIDC provider's "given_name"/"family_name".
+func (f UserInfoFields) GetStringFromKeysOrEmpty(keys ...string) string {
+	for _, key := range keys {
+		if v := f.GetString(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// GetBoolean returns the boolean value stored under key, defaulting to
+// false when the key is absent or not a boolean.
+func (f UserInfoFields) GetBoolean(key string) bool {
+	v, ok := f[key].(bool)
+	if !ok {
+		return false
+	}
+	return v
+}
+
+// IssuerRegistry holds the configured OAuth2/OIDC providers this server
+// will federate login with, keyed by the path segment used in
+// /users/oauth/:provider/...
+type IssuerRegistry struct {
+	providers map[string]config.OAuthProviderConfig
+}
+
+// NewIssuerRegistry loads the provider configuration from the
+// environment via the configs package.
+func NewIssuerRegistry() *IssuerRegistry {
+	return &IssuerRegistry{providers: config.EnvOAuthProviders()}
+}
+
+func (r *IssuerRegistry) get(provider string) (config.OAuthProviderConfig, error) {
+	cfg, ok := r.providers[strings.ToLower(provider)]
+	if !ok {
+		return config.OAuthProviderConfig{}, fmt.Errorf("unknown oauth provider: %s", provider)
+	}
+	return cfg, nil
+}
+
+func (u *User) oauthLogin(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+
+	cfg, err := u.server.oauthIssuers.get(provider)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	state, err := generateOauthState()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if err := Rdb.Set(ctx, oauthStateKey(state), provider, oauthStateTimeout).Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	authURL, err := url.Parse(cfg.AuthURL)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	query := authURL.Query()
+	query.Set("client_id", cfg.ClientID)
+	query.Set("redirect_uri", cfg.RedirectURL)
+	query.Set("response_type", "code")
+	query.Set("scope", strings.Join(cfg.Scopes, " "))
+	query.Set("state", state)
+	authURL.RawQuery = query.Encode()
+
+	ctx.Redirect(http.StatusTemporaryRedirect, authURL.String())
+}
+
+func (u *User) oauthCallback(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+
+	cfg, err := u.server.oauthIssuers.get(provider)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	state := ctx.Query("state")
+	code := ctx.Query("code")
+
+	if state == "" || code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing state or code",
+		})
+		return
+	}
+
+	storedProvider, err := Rdb.Get(ctx, oauthStateKey(state)).Result()
+	if err != nil || storedProvider != provider {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid or expired oauth state",
+		})
+		return
+	}
+	Rdb.Del(ctx, oauthStateKey(state))
+
+	token, err := exchangeOauthCode(ctx, cfg, code)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	userinfo, err := fetchUserInfo(ctx, cfg, token)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	sub := userinfo.GetStringFromKeysOrEmpty("sub", "id")
+	if sub == "" {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": "provider did not return a subject identifier",
+		})
+		return
+	}
+
+	existing, err := u.server.queries.GetUserByExternalSubject(context.Background(), sql.NullString{String: sub, Valid: true})
+
+	var userResponse UserResponse
+
+	switch {
+	case err == nil:
+		userResponse = UserResponse{
+			ID:        existing.ID,
+			Lastname:  existing.Lastname,
+			Firstname: existing.Firstname,
+			Email:     existing.Email,
+			Phone:     existing.Phone,
+			Address:   existing.Address,
+			Picture:   existing.AvatarUrl.String,
+			IsAdmin:   existing.IsAdmin,
+			CreatedAt: existing.CreatedAt,
+			UpdatedAt: existing.UpdatedAt,
+		}
+	case err == sql.ErrNoRows:
+		provisioned, provisionErr := u.provisionOauthUser(sub, userinfo)
+		if provisionErr != nil {
+			handleCreateUserError(ctx, provisionErr)
+			return
+		}
+		userResponse = provisioned
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	// An OAuth callback is a login the same as a password one, so it has
+	// to honour MFA the same way too: if the account has it enabled, hand
+	// back a pre-auth token scoped to /mfa/verify instead of a full one.
+	// (The password login handler needs the identical check; it isn't
+	// part of this change because it lives outside this module.)
+	mfaState, err := u.server.queries.GetUserMFAState(context.Background(), userResponse.ID)
+	if err == nil && mfaState.MfaEnabled {
+		preAuthToken, err := tokenManager.GenerateToken(userResponse.ID, utils.PreAuthRole)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"Error": err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "MFA required, call /users/mfa/verify with this token",
+			"data": gin.H{
+				"mfa_required": true,
+				"token":        preAuthToken,
+			},
+		})
+		return
+	}
+
+	role := utilsRoleFor(userResponse.IsAdmin)
+
+	accessToken, err := tokenManager.GenerateToken(userResponse.ID, role)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "login successful",
+		"data": gin.H{
+			"user":  userResponse,
+			"token": accessToken,
+		},
+	})
+}
+
+func (u *User) provisionOauthUser(sub string, userinfo UserInfoFields) (UserResponse, error) {
+	email := strings.ToLower(userinfo.GetStringFromKeysOrEmpty("email"))
+	if email == "" {
+		return UserResponse{}, errors.New("provider did not return an email address")
+	}
+
+	// email is the login key (GetUserByEmail is how password auth looks
+	// a user up), so a local account may already own it from a password
+	// signup. Link this subject to that account instead of trying to
+	// INSERT a second row with the same email, which would just fail on
+	// the unique constraint.
+	byEmail, err := u.server.queries.GetUserByEmail(context.Background(), email)
+	switch {
+	case err == nil:
+		linked, err := u.server.queries.LinkExternalSubject(context.Background(), db.LinkExternalSubjectParams{
+			ID:              byEmail.ID,
+			ExternalSubject: sql.NullString{String: sub, Valid: true},
+		})
+		if err != nil {
+			return UserResponse{}, err
+		}
+
+		return UserResponse{
+			ID:        linked.ID,
+			Lastname:  linked.Lastname,
+			Firstname: linked.Firstname,
+			Email:     linked.Email,
+			Phone:     linked.Phone,
+			Address:   linked.Address,
+			Picture:   linked.AvatarUrl.String,
+			IsAdmin:   linked.IsAdmin,
+			CreatedAt: linked.CreatedAt,
+			UpdatedAt: linked.UpdatedAt,
+		}, nil
+	case err != sql.ErrNoRows:
+		return UserResponse{}, err
+	}
+
+	firstname := userinfo.GetStringFromKeysOrEmpty("given_name", "first_name", "name")
+	lastname := userinfo.GetStringFromKeysOrEmpty("family_name", "last_name")
+	picture := userinfo.GetStringFromKeysOrEmpty("picture", "avatar_url")
+
+	// The provider doesn't give us a phone number, and a blank one on
+	// every OAuth signup would collide if phone ever gets a unique
+	// constraint (it already has a fixed-length format elsewhere). sub
+	// is already guaranteed unique per provider via
+	// GetUserByExternalSubject, so reuse it as a placeholder instead of
+	// leaving the column blank.
+	placeholderPhone := "oauth:" + sub
+
+	unusablePassword, err := randomUnusablePasswordHash()
+	if err != nil {
+		return UserResponse{}, err
+	}
+
+	arg := db.CreateOAuthUserParams{
+		Lastname:        lastname,
+		Firstname:       firstname,
+		Email:           email,
+		Phone:           placeholderPhone,
+		HashedPassword:  unusablePassword,
+		ExternalSubject: sql.NullString{String: sub, Valid: true},
+		AvatarUrl:       sql.NullString{String: picture, Valid: picture != ""},
+	}
+
+	created, err := u.server.queries.CreateOAuthUser(context.Background(), arg)
+	if err != nil {
+		return UserResponse{}, err
+	}
+
+	return UserResponse{
+		ID:        created.ID,
+		Lastname:  created.Lastname,
+		Firstname: created.Firstname,
+		Email:     created.Email,
+		Phone:     created.Phone,
+		Address:   created.Address,
+		Picture:   created.AvatarUrl.String,
+		IsAdmin:   created.IsAdmin,
+		CreatedAt: created.CreatedAt,
+		UpdatedAt: created.UpdatedAt,
+	}, nil
+}
+
+// randomUnusablePasswordHash produces a hash of a password nobody knows,
+// so an OAuth-provisioned account (which has no password of its own)
+// can never be logged into via the password flow until the user sets
+// one through /users/update/password.
+func randomUnusablePasswordHash() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return utils.GenerateHashPassword(hex.EncodeToString(raw))
+}
+
+func generateOauthState() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func oauthStateKey(state string) string {
+	return "oauth_state:" + state
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+func exchangeOauthCode(ctx context.Context, cfg config.OAuthProviderConfig, code string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	form.Set("redirect_uri", cfg.RedirectURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp oauthTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", err
+	}
+	if tokenResp.AccessToken == "" {
+		return "", errors.New("token response did not contain an access token")
+	}
+
+	return tokenResp.AccessToken, nil
+}
+
+func fetchUserInfo(ctx context.Context, cfg config.OAuthProviderConfig, accessToken string) (UserInfoFields, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	fields := UserInfoFields{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// utilsRoleFor mirrors the role values tokenManager already issues for
+// password-based logins so OAuth users get the exact same claims shape.
+func utilsRoleFor(isAdmin bool) string {
+	if isAdmin {
+		return utils.AdminRole
+	}
+	return utils.UserRole
+}
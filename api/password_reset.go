@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	config "github.com/olartbaraq/spectrumshelf/configs"
+	db "github.com/olartbaraq/spectrumshelf/db/sqlc"
+	"github.com/olartbaraq/spectrumshelf/utils"
+)
+
+const (
+	resetTokenTimeout    = 15 * time.Minute
+	resetEmailRateLimit  = 5  // per email, per hour
+	resetIPRateLimit     = 20 // per IP, per hour
+	resetRateLimitWindow = time.Hour
+)
+
+type ResetPasswordInput struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8" validate:"passwordStrength"`
+}
+
+// PasswordResetMailData is the template data for the "password_reset" mail.
+type PasswordResetMailData struct {
+	ResetURL  string
+	ExpiresIn string
+}
+
+// forgotPassword issues a signed, single-use magic link instead of the
+// deprecated 4-digit sendCodetoUser/verifyCode flow. It never requires
+// (or reveals) whether the address exists, beyond the response always
+// reading "success" - the absence of an email is not an oracle.
+func (u *User) forgotPassword(ctx *gin.Context) {
+	input := ForgotPasswordInput{}
+
+	if err := ctx.ShouldBindQuery(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if email == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "no input entered",
+		})
+		return
+	}
+
+	if limited, err := rateLimited(ctx, "pwreset:email:"+email, resetEmailRateLimit, resetRateLimitWindow); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	} else if limited {
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "too many password reset requests, try again later",
+		})
+		return
+	}
+
+	if limited, err := rateLimited(ctx, "pwreset:ip:"+ctx.ClientIP(), resetIPRateLimit, resetRateLimitWindow); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	} else if limited {
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "too many password reset requests, try again later",
+		})
+		return
+	}
+
+	user, err := u.server.queries.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		// Same response whether or not the address is registered, so the
+		// endpoint can't be used to enumerate accounts.
+		ctx.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "if that email is registered, a reset link has been sent",
+		})
+		return
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	if err := Rdb.Set(ctx, resetTokenKey(token), user.ID, resetTokenTimeout).Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	resetURL := fmt.Sprintf("%s/reset?token=%s", config.EnvAppBaseURL(), token)
+
+	if err := u.server.mailer.SendTemplate(ctx, user.Email, "password_reset", PasswordResetMailData{
+		ResetURL:  resetURL,
+		ExpiresIn: resetTokenTimeout.String(),
+	}); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "if that email is registered, a reset link has been sent",
+	})
+}
+
+// resetPassword completes the magic-link flow. It deliberately does not
+// require the caller's JWT: a forgotten password means there isn't one
+// to present, which is exactly the gap the old verifyCode->updatePassword
+// chain left open.
+func (u *User) resetPassword(ctx *gin.Context) {
+	input := ResetPasswordInput{}
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		stringErr := err.Error()
+		if strings.Contains(stringErr, "passwordStrength") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"Error": `
+						"Password must be minimum of 8 characters",
+						"Password must be contain at least a number",
+						"Password must be contain at least a symbol",
+						"Password must be contain a upper case letter"
+						`,
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"Error": stringErr,
+		})
+		return
+	}
+
+	userId, err := Rdb.GetDel(ctx, resetTokenKey(input.Token)).Int64()
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid or expired reset token",
+		})
+		return
+	}
+
+	hashedPassword, err := utils.GenerateHashPassword(input.NewPassword)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	arg := db.UpdateUserPasswordParams{
+		ID:             userId,
+		HashedPassword: hashedPassword,
+		UpdatedAt:      time.Now(),
+	}
+
+	if _, err := u.server.queries.UpdateUserPassword(context.Background(), arg); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "password reset successfully",
+	})
+}
+
+func generateResetToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// resetTokenKey hashes the token before using it as a Redis key so the
+// raw, emailed token never needs to be stored anywhere.
+func resetTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "pwreset:" + hex.EncodeToString(sum[:])
+}
+
+// rateLimited implements a fixed-window counter. SetNX seeds the counter
+// and its expiry in one step the first time a key is seen in a window,
+// so there's no gap between the counter existing and its TTL being set -
+// unlike INCR-then-EXPIRE, a crash or error between the two steps can't
+// leave the key stuck with no expiry and the caller locked out forever.
+// Every request after the first just increments the same counter, and
+// the window resets once it expires.
+func rateLimited(ctx context.Context, key string, limit int64, window time.Duration) (bool, error) {
+	fullKey := "ratelimit:" + key
+
+	if err := Rdb.SetNX(ctx, fullKey, 0, window).Err(); err != nil {
+		return false, err
+	}
+
+	count, err := Rdb.Incr(ctx, fullKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > limit, nil
+}
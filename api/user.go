@@ -7,9 +7,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,7 +15,6 @@ import (
 	db "github.com/olartbaraq/spectrumshelf/db/sqlc"
 	"github.com/olartbaraq/spectrumshelf/utils"
 	"github.com/redis/go-redis/v9"
-	"gopkg.in/gomail.v2"
 )
 
 type User struct {
@@ -47,6 +44,11 @@ type ForgotPasswordInput struct {
 	Email string `form:"email"`
 }
 
+type ListUsersInput struct {
+	Limit  int32 `form:"limit"`
+	Offset int32 `form:"offset"`
+}
+
 type UserResponse struct {
 	ID        int64     `json:"id"`
 	Lastname  string    `json:"lastname"`
@@ -54,6 +56,7 @@ type UserResponse struct {
 	Phone     string    `json:"phone"`
 	Address   string    `json:"address"`
 	Email     string    `json:"email"`
+	Picture   string    `json:"picture,omitempty"`
 	IsAdmin   bool      `json:"is_admin"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -66,14 +69,32 @@ type DeleteUserParam struct {
 func (u User) router(server *Server) {
 	u.server = server
 	serverGroup := server.router.Group("/users")
-	serverGroup.GET("/allUsers", u.listUsers, AuthenticatedMiddleware())
-	serverGroup.PUT("/update", u.updateUser, AuthenticatedMiddleware())
-	serverGroup.PUT("/update/password", u.updatePassword, AuthenticatedMiddleware())
-	serverGroup.DELETE("/deactivate", u.deleteUser, AuthenticatedMiddleware())
-	serverGroup.GET("/profile", u.userProfile, AuthenticatedMiddleware())
-	serverGroup.GET("/get_email", u.getUserEmail, AuthenticatedMiddleware())
-	serverGroup.GET("/send_code_to_user", u.sendCodetoUser)
-	serverGroup.POST("/verify_code", u.verifyCode)
+	// Gin runs a route's handlers in the order they're passed, so the
+	// middleware must come before the handler it's meant to guard - not
+	// after, where it would run too late to do anything.
+	serverGroup.GET("/allUsers", AuthenticatedMiddleware(), RoleMiddleware(utils.AdminRole), u.listUsers)
+	serverGroup.PUT("/update", AuthenticatedMiddleware(), u.updateUser)
+	serverGroup.PUT("/update/password", AuthenticatedMiddleware(), u.updatePassword)
+	serverGroup.DELETE("/deactivate", AuthenticatedMiddleware(), u.deleteUser)
+	serverGroup.GET("/profile", AuthenticatedMiddleware(), u.userProfile)
+	serverGroup.GET("/get_email", AuthenticatedMiddleware(), u.getUserEmail)
+	serverGroup.GET("/send_code_to_user", u.sendCodetoUser) // Deprecated: use /password/forgot.
+	serverGroup.POST("/verify_code", u.verifyCode)          // Deprecated: use /password/reset.
+	serverGroup.GET("/password/forgot", u.forgotPassword)
+	serverGroup.POST("/password/reset", u.resetPassword)
+	serverGroup.GET("/oauth/:provider/login", u.oauthLogin)
+	serverGroup.GET("/oauth/:provider/callback", u.oauthCallback)
+	serverGroup.POST("/mfa/enroll", AuthenticatedMiddleware(), u.mfaEnroll)
+	serverGroup.POST("/mfa/activate", AuthenticatedMiddleware(), u.mfaActivate)
+	serverGroup.POST("/mfa/verify", u.mfaVerify)
+	serverGroup.POST("/mfa/recovery_codes", AuthenticatedMiddleware(), u.mfaRecoveryCodes)
+
+	adminGroup := serverGroup.Group("/admin", AuthenticatedMiddleware(), RoleMiddleware(utils.AdminRole))
+	adminGroup.GET("/list", u.adminListUsers)
+	adminGroup.POST("/promote", u.adminPromoteUser)
+	adminGroup.POST("/demote", u.adminDemoteUser)
+	adminGroup.POST("/deactivate/:id", u.adminDeactivateUser)
+	adminGroup.POST("/invite", u.adminInviteUser)
 }
 
 //var VerificationCodes = make(map[int64]VerificationCode)
@@ -85,6 +106,12 @@ type VerificationResponse struct {
 	Email         string
 }
 
+// VerificationMailData is the template data for the "verification" mail.
+type VerificationMailData struct {
+	Code      string
+	ExpiresIn string
+}
+
 var Rdb = redis.NewClient(&redis.Options{
 	Addr:     "localhost:6379",
 	Password: config.EnvRedisPassword(),
@@ -122,39 +149,51 @@ func returnIdRole(tokenString string) (int64, string, error) {
 	return userId, role, nil
 }
 
-func (u *User) listUsers(ctx *gin.Context) {
-
+// currentUserID extracts and verifies the bearer token on ctx and returns
+// the user id it carries. It's what every handler behind plain
+// AuthenticatedMiddleware() uses to identify the caller, since that
+// middleware doesn't attach anything to the gin context itself - only
+// RoleMiddleware does, for the routes that need a role check too.
+func currentUserID(ctx *gin.Context) (int64, error) {
 	tokenString, err := extractTokenFromRequest(ctx)
+	if err != nil {
+		return 0, err
+	}
 
+	userId, _, err := returnIdRole(tokenString)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized: Missing or invalid token",
-		})
-		return
+		return 0, err
 	}
 
-	_, role, err := returnIdRole(tokenString)
+	return userId, nil
+}
 
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"Error":  err.Error(),
-			"status": "failed to verify token",
+// Deprecated: listUsers is superseded by the paged, searchable
+// /users/admin/list. Kept for one release so existing clients have time
+// to migrate; unlike before, it now takes the same limit/offset as the
+// endpoint replacing it instead of hard-coding the first page.
+func (u *User) listUsers(ctx *gin.Context) {
+	adminId := ctx.MustGet("user_id").(int64)
+
+	if err := requireFreshMFA(ctx, adminId, mfaEnabledFor(u, adminId)); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
 		})
 		ctx.Abort()
 		return
 	}
 
-	if role != utils.AdminRole {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
+	input := ListUsersInput{Limit: 10, Offset: 0}
+	if err := ctx.ShouldBindQuery(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"Error": err.Error(),
 		})
-		ctx.Abort()
 		return
 	}
 
 	arg := db.ListAllUsersParams{
-		Limit:  10,
-		Offset: 0,
+		Limit:  input.Limit,
+		Offset: input.Offset,
 	}
 
 	users, err := u.server.queries.ListAllUsers(context.Background(), arg)
@@ -192,9 +231,7 @@ func (u *User) listUsers(ctx *gin.Context) {
 }
 
 func (u *User) deleteUser(ctx *gin.Context) {
-
-	tokenString, err := extractTokenFromRequest(ctx)
-
+	userId, err := currentUserID(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Unauthorized: Missing or invalid token",
@@ -203,12 +240,9 @@ func (u *User) deleteUser(ctx *gin.Context) {
 		return
 	}
 
-	userId, _, err := returnIdRole(tokenString)
-
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"Error":  err.Error(),
-			"status": "failed to verify token",
+	if err := requireFreshMFA(ctx, userId, mfaEnabledFor(u, userId)); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
 		})
 		ctx.Abort()
 		return
@@ -216,14 +250,6 @@ func (u *User) deleteUser(ctx *gin.Context) {
 
 	id := DeleteUserParam{}
 
-	if userId != id.ID {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized: invalid token",
-		})
-		ctx.Abort()
-		return
-	}
-
 	if err := ctx.ShouldBindJSON(&id); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"Error": err.Error(),
@@ -231,9 +257,15 @@ func (u *User) deleteUser(ctx *gin.Context) {
 		return
 	}
 
-	err = u.server.queries.DeleteUser(context.Background(), id.ID)
+	if userId != id.ID {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Unauthorized: invalid token",
+		})
+		ctx.Abort()
+		return
+	}
 
-	if err != nil {
+	if err := u.server.queries.DeleteUser(context.Background(), id.ID); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"Error": err.Error(),
 		})
@@ -247,23 +279,11 @@ func (u *User) deleteUser(ctx *gin.Context) {
 }
 
 func (u *User) updateUser(ctx *gin.Context) {
-
-	tokenString, err := extractTokenFromRequest(ctx)
-
+	userId, err := currentUserID(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Unauthorized: Missing or invalid token",
 		})
-		return
-	}
-
-	userId, _, err := returnIdRole(tokenString)
-
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"Error":  err.Error(),
-			"status": "failed to verify token",
-		})
 		ctx.Abort()
 		return
 	}
@@ -320,26 +340,14 @@ func (u *User) updateUser(ctx *gin.Context) {
 }
 
 func (u *User) userProfile(ctx *gin.Context) {
-	value, exist := ctx.Get("id")
-
-	if !exist {
+	userId, err := currentUserID(ctx)
+	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"status":  exist,
 			"message": "Unauthorized",
 		})
 		return
 	}
 
-	userId, ok := value.(int64)
-
-	if !ok {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"status":  exist,
-			"message": "Issue Encountered, try again later",
-		})
-		return
-	}
-
 	user, err := u.server.queries.GetUserById(context.Background(), userId)
 
 	if err == sql.ErrNoRows {
@@ -429,6 +437,9 @@ func (u *User) getUserEmail(ctx *gin.Context) {
 	})
 }
 
+// Deprecated: sendCodetoUser is superseded by forgotPassword's signed
+// magic link. Kept for one release so existing clients have time to
+// migrate.
 func (u *User) sendCodetoUser(ctx *gin.Context) {
 	// Bind User Input for validation
 
@@ -493,75 +504,20 @@ func (u *User) sendCodetoUser(ctx *gin.Context) {
 		return
 	}
 
-	// TODO: Send generated code to the user email address
-	var wg sync.WaitGroup
-
-	errorChan := make(chan error)
-
-	wg.Add(1)
-
-	//fmt.Println("About to enter send email goroutine")
-
-	go func(userEmail, code string, e chan<- error) {
-		defer wg.Done()
-
-		//fmt.Println("About to read html")
-		filereader, err := os.ReadFile("verification.html")
-		if err != nil {
-			e <- err
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"statusCode": http.StatusInternalServerError,
-				"Error":      err.Error(),
-			})
-			ctx.Abort()
-			return
-		}
-
-		messagetoSend := string(filereader)
-
-		//fmt.Println("File converted")
-
-		sender := config.EnvGoogleUsername()
-		password := config.EnvGooglePassword()
-		smtpHost := "smtp.gmail.com"
-		smtpPort := 587
-
-		message := gomail.NewMessage()
-		message.SetHeader("From", sender)
-		message.SetHeader("To", userEmail)
-		message.SetHeader("Subject", "Verification Code")
-		message.SetBody("text/plain", "Your verification code is: "+code)
-		message.AddAlternative("text/html", messagetoSend+"Your verification code is: "+code)
-
-		// Set up the email server configuration
-		dialer := gomail.NewDialer(smtpHost, smtpPort, sender, password)
-
-		//fmt.Println("we got to dialer")
-
-		// Send the email
-		if err := dialer.DialAndSend(message); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"statusCode": http.StatusInternalServerError,
-				"Error":      err.Error(),
-			})
-			e <- err
-			return
-		}
-
-		//fmt.Println("we sent the mail")
-
-		e <- nil
-
-	}(userGot.Email, returnedCode, errorChan)
-
-	go func() {
-		wg.Wait()
-		close(errorChan)
-	}()
-
-	errVal := <-errorChan
-
-	//fmt.Println("Email goroutine ended")
+	// Send the generated code to the user's email address via the
+	// configured mail transport (SMTP/sendmail/no-op), instead of
+	// dialing SMTP and reading the template off disk on every request.
+	errVal := u.server.mailer.SendTemplate(ctx, userGot.Email, "verification", VerificationMailData{
+		Code:      returnedCode,
+		ExpiresIn: timeout.String(),
+	})
+	if errVal != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"Error":      errVal.Error(),
+		})
+		return
+	}
 
 	coderesponse := VerificationResponse{
 		UserID:        userGot.ID,
@@ -581,6 +537,9 @@ func (u *User) sendCodetoUser(ctx *gin.Context) {
 	//VerificationCodes[userGot.ID] = VerificationCode{Code: returnedCode, ExpiresAt: returnedTime}
 }
 
+// Deprecated: verifyCode is superseded by resetPassword's signed
+// magic link. Kept for one release so existing clients have time to
+// migrate.
 func (u *User) verifyCode(ctx *gin.Context) {
 
 	codeInput := UserCodeInput{}
@@ -642,22 +601,18 @@ func (u *User) verifyCode(ctx *gin.Context) {
 }
 
 func (u *User) updatePassword(ctx *gin.Context) {
-
-	tokenString, err := extractTokenFromRequest(ctx)
-
+	userId, err := currentUserID(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Unauthorized: Missing or invalid token",
 		})
+		ctx.Abort()
 		return
 	}
 
-	userId, _, err := returnIdRole(tokenString)
-
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"Error":  err.Error(),
-			"status": "failed to verify token",
+	if err := requireFreshMFA(ctx, userId, mfaEnabledFor(u, userId)); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
 		})
 		ctx.Abort()
 		return
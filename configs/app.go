@@ -0,0 +1,9 @@
+package configs
+
+import "os"
+
+// EnvAppBaseURL returns the public base URL this API is served behind,
+// used to build links (e.g. password reset) that get emailed out.
+func EnvAppBaseURL() string {
+	return os.Getenv("APP_BASE_URL")
+}
@@ -0,0 +1,54 @@
+package configs
+
+import (
+	"os"
+	"strings"
+)
+
+// OAuthProviderConfig holds everything needed to drive an OAuth2/OIDC
+// authorization code flow against a single identity provider.
+type OAuthProviderConfig struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	RedirectURL  string
+}
+
+// EnvOAuthProviders builds the issuer registry from environment variables.
+// Each provider is configured through a block of
+// OAUTH_<PROVIDER>_{CLIENT_ID,CLIENT_SECRET,AUTH_URL,TOKEN_URL,USERINFO_URL,SCOPES,REDIRECT_URL}
+// variables. Providers with no client id configured are skipped.
+func EnvOAuthProviders() map[string]OAuthProviderConfig {
+	providers := map[string]OAuthProviderConfig{}
+
+	for _, name := range []string{"google", "github", "oidc"} {
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if clientID == "" {
+			continue
+		}
+
+		scopes := []string{}
+		if raw := os.Getenv(prefix + "SCOPES"); raw != "" {
+			scopes = strings.Split(raw, ",")
+		}
+
+		providers[name] = OAuthProviderConfig{
+			Name:         name,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			AuthURL:      os.Getenv(prefix + "AUTH_URL"),
+			TokenURL:     os.Getenv(prefix + "TOKEN_URL"),
+			UserInfoURL:  os.Getenv(prefix + "USERINFO_URL"),
+			Scopes:       scopes,
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+	}
+
+	return providers
+}
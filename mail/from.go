@@ -0,0 +1,14 @@
+package mail
+
+import "net/mail"
+
+// ParseFromAddress splits a "Name <addr@host>" (or bare "addr@host")
+// string into its display name and address, the way gomail's
+// SetAddressHeader expects them.
+func ParseFromAddress(raw string) (name string, address string, err error) {
+	parsed, err := mail.ParseAddress(raw)
+	if err != nil {
+		return "", "", err
+	}
+	return parsed.Name, parsed.Address, nil
+}
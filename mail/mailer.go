@@ -0,0 +1,14 @@
+// Package mail provides a transport-agnostic way to send templated
+// email from the rest of the API. Handlers talk to the Mailer
+// interface; SMTP, sendmail and a no-op logging transport are the
+// concrete implementations.
+package mail
+
+import "context"
+
+// Mailer renders the named template with data and delivers it to to.
+// Implementations own their own transport (SMTP, sendmail, a log-only
+// stub for tests) and are safe for concurrent use.
+type Mailer interface {
+	SendTemplate(ctx context.Context, to string, templateName string, data any) error
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// SentMail records one call made through a NoopMailer, for tests that
+// want to assert what would have been sent without a real transport.
+type SentMail struct {
+	To           string
+	TemplateName string
+	Data         any
+}
+
+// NoopMailer logs instead of sending and keeps every call in memory.
+// It requires no network access, which is what makes the handlers that
+// depend on Mailer unit-testable.
+type NoopMailer struct {
+	mu   sync.Mutex
+	Sent []SentMail
+}
+
+func NewNoopMailer() *NoopMailer {
+	return &NoopMailer{}
+}
+
+func (m *NoopMailer) SendTemplate(ctx context.Context, to string, templateName string, data any) error {
+	log.Printf("mail (noop): to=%s template=%s data=%+v", to, templateName, data)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sent = append(m.Sent, SentMail{To: to, TemplateName: templateName, Data: data})
+
+	return nil
+}
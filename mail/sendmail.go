@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// SendmailMailer shells out to a local MTA binary (sendmail-compatible,
+// e.g. Postfix's sendmail wrapper) instead of dialing SMTP directly.
+// Useful on hosts where outbound SMTP is already handled by the system
+// mail relay.
+type SendmailMailer struct {
+	// BinaryPath defaults to "/usr/sbin/sendmail" when empty.
+	BinaryPath string
+	From       string
+	templates  map[string]templateSet
+}
+
+func NewSendmailMailer(binaryPath, from string) (*SendmailMailer, error) {
+	templates, err := loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	if binaryPath == "" {
+		binaryPath = "/usr/sbin/sendmail"
+	}
+
+	return &SendmailMailer{
+		BinaryPath: binaryPath,
+		From:       from,
+		templates:  templates,
+	}, nil
+}
+
+func (m *SendmailMailer) SendTemplate(ctx context.Context, to string, templateName string, data any) error {
+	tmpl, ok := m.templates[templateName]
+	if !ok {
+		return fmt.Errorf("mail: unknown template %q", templateName)
+	}
+
+	var textBody bytes.Buffer
+	if err := tmpl.text.Execute(&textBody, data); err != nil {
+		return fmt.Errorf("mail: rendering %s.txt: %w", templateName, err)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", m.From)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", subjects[templateName])
+	message.WriteString("\r\n")
+	message.Write(textBody.Bytes())
+
+	cmd := exec.CommandContext(ctx, m.BinaryPath, "-t")
+	cmd.Stdin = &message
+
+	return cmd.Run()
+}
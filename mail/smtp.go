@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"gopkg.in/gomail.v2"
+)
+
+// TLSMode selects how SMTPMailer secures its connection to the server.
+type TLSMode int
+
+const (
+	TLSModeStartTLS TLSMode = iota
+	TLSModeImplicit
+	TLSModeNone
+)
+
+// SMTPConfig configures the SMTP transport. From must be in
+// "Name <addr@host>" form.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLSMode  TLSMode
+}
+
+type SMTPMailer struct {
+	cfg       SMTPConfig
+	fromName  string
+	fromAddr  string
+	templates map[string]templateSet
+}
+
+// NewSMTPMailer parses the embedded templates and the configured from
+// address once, so SendTemplate never fails for a reason unrelated to
+// the specific send.
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	templates, err := loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	fromName, fromAddr, err := ParseFromAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid from address %q: %w", cfg.From, err)
+	}
+
+	return &SMTPMailer{
+		cfg:       cfg,
+		fromName:  fromName,
+		fromAddr:  fromAddr,
+		templates: templates,
+	}, nil
+}
+
+func (m *SMTPMailer) SendTemplate(ctx context.Context, to string, templateName string, data any) error {
+	tmpl, ok := m.templates[templateName]
+	if !ok {
+		return fmt.Errorf("mail: unknown template %q", templateName)
+	}
+
+	var htmlBody, textBody bytes.Buffer
+
+	if err := tmpl.html.Execute(&htmlBody, data); err != nil {
+		return fmt.Errorf("mail: rendering %s.html: %w", templateName, err)
+	}
+	if err := tmpl.text.Execute(&textBody, data); err != nil {
+		return fmt.Errorf("mail: rendering %s.txt: %w", templateName, err)
+	}
+
+	message := gomail.NewMessage()
+	message.SetAddressHeader("From", m.fromAddr, m.fromName)
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", subjects[templateName])
+	message.SetBody("text/plain", textBody.String())
+	message.AddAlternative("text/html", htmlBody.String())
+
+	dialer := gomail.NewDialer(m.cfg.Host, m.cfg.Port, m.cfg.Username, m.cfg.Password)
+	if m.cfg.TLSMode == TLSModeNone {
+		dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // explicit opt-in for local/dev transports
+	}
+	dialer.SSL = m.cfg.TLSMode == TLSModeImplicit
+
+	return dialer.DialAndSend(message)
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*.html templates/*.txt
+var templateFS embed.FS
+
+// templateSet is a single logical email (e.g. "verification") rendered
+// in both an HTML and a plain-text alternative.
+type templateSet struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// subjects maps a template name to the subject line sent with it. Kept
+// alongside the templates themselves so adding an email only means
+// adding one entry here plus the two template files.
+var subjects = map[string]string{
+	"verification":   "Your verification code",
+	"password_reset": "Reset your password",
+	"invite":         "You've been invited",
+}
+
+// loadTemplates parses every embedded template pair once at startup so
+// handlers never touch the filesystem on the request path.
+func loadTemplates() (map[string]templateSet, error) {
+	sets := make(map[string]templateSet, len(subjects))
+
+	for name := range subjects {
+		html, err := htmltemplate.ParseFS(templateFS, fmt.Sprintf("templates/%s.html", name))
+		if err != nil {
+			return nil, fmt.Errorf("mail: parsing %s.html: %w", name, err)
+		}
+
+		text, err := texttemplate.ParseFS(templateFS, fmt.Sprintf("templates/%s.txt", name))
+		if err != nil {
+			return nil, fmt.Errorf("mail: parsing %s.txt: %w", name, err)
+		}
+
+		sets[name] = templateSet{html: html, text: text}
+	}
+
+	return sets, nil
+}